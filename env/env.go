@@ -0,0 +1,67 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+// Package env loads the application's TOML configuration file into typed
+// structs.
+package env
+
+import (
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+//TomlConfig is the root of our app's .toml config file
+type TomlConfig struct {
+	Server          ServerConfig
+	Oauth2Providers map[string]Oauth2ProviderConfig `toml:"oauth2_providers"`
+	TokenStore      TokenStoreConfig                `toml:"token_store"`
+	Sync            SyncConfig                      `toml:"sync"`
+	RateLimit       RateLimitConfig                 `toml:"rate_limit"`
+}
+
+//ServerConfig holds the settings for our HTTP server
+type ServerConfig struct {
+	Port                   string
+	ShutdownTimeoutSeconds int `toml:"shutdown_timeout_seconds"`
+}
+
+//Oauth2ProviderConfig holds the per-provider OAuth2 app credentials, keyed in
+//TomlConfig.Oauth2Providers by provider name (e.g. "spotify", "google")
+type Oauth2ProviderConfig struct {
+	ClientID     string   `toml:"client_id"`
+	ClientSecret string   `toml:"client_secret"`
+	RedirectURL  string   `toml:"redirect_url"`
+	Scopes       []string `toml:"scopes"`
+}
+
+//TokenStoreConfig holds the settings for the persistent token store
+type TokenStoreConfig struct {
+	Path          string `toml:"path"`           // path to the BoltDB file on disk
+	EncryptionKey string `toml:"encryption_key"` // hex-encoded 32-byte AES-256 key
+	SessionSecret string `toml:"session_secret"` // key used to sign session cookies
+}
+
+//SyncConfig holds the settings for the bulk library sync subsystem
+type SyncConfig struct {
+	DBPath  string  `toml:"db_path"` // path to the SQLite file on disk
+	Workers int     `toml:"workers"` // bounded worker pool size for page fan-out
+	QPS     float64 `toml:"qps"`     // client-side request rate for this job's pagination, overriding the transport default
+}
+
+//RateLimitConfig holds the settings for the client-side rate limiter/retrier
+//wrapping outbound Spotify Web API requests
+type RateLimitConfig struct {
+	QPS        float64 `toml:"qps"`         // steady-state requests/sec; 0 uses the transport's default
+	MaxRetries int     `toml:"max_retries"` // retries for 429/502/503/504 responses; 0 uses the transport's default
+}
+
+//LoadTOMLFile reads and decodes fileName into a TomlConfig
+func LoadTOMLFile(fileName string) (*TomlConfig, error) {
+	var cfg TomlConfig
+	if _, err := toml.DecodeFile(fileName, &cfg); err != nil {
+		return nil, fmt.Errorf("env: decoding %q: %w", fileName, err)
+	}
+	return &cfg, nil
+}
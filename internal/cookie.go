@@ -0,0 +1,32 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+// Package internal holds small helpers shared across the app's packages that
+// aren't meant to be imported outside this module.
+package internal
+
+import (
+	"net/http"
+	"time"
+)
+
+//NewCookie returns a short-lived cookie used to shuttle one-off values (e.g.
+//oauth state) between a redirect and its callback
+func NewCookie(name, value string) *http.Cookie {
+	return NewCookieWithTTL(name, value, 15*time.Minute)
+}
+
+//NewCookieWithTTL returns a cookie that expires after ttl, for values (e.g. a
+//signed session) that need to outlive a single redirect round-trip
+func NewCookieWithTTL(name, value string, ttl time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(ttl),
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+//ErrInvalidSignature is returned by Unsign when a signed value's signature
+//doesn't match, either because it was tampered with or was signed with a
+//different secret
+var ErrInvalidSignature = errors.New("internal: invalid signature")
+
+//Sign appends an HMAC-SHA256 signature of value, keyed by secret, so it can
+//be round-tripped through an untrusted cookie and later verified with Unsign
+func Sign(secret, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return value + "." + sig
+}
+
+//Unsign verifies a value produced by Sign and returns the original value
+func Unsign(secret, signed string) (string, error) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", ErrInvalidSignature
+	}
+	value, sig := signed[:i], signed[i+1:]
+	wantSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	if !hmac.Equal(wantSig, mac.Sum(nil)) {
+		return "", ErrInvalidSignature
+	}
+	return value, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const googleUserInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+
+//defaultGoogleScopes grants read-only access to YouTube Music along with
+//basic profile info
+var defaultGoogleScopes = []string{
+	"https://www.googleapis.com/auth/youtube.readonly",
+	"profile",
+	"email",
+}
+
+//googleProvider lets a user link their Google account, e.g. for YouTube
+//Music, via Google's standard OAuth2 endpoints
+type googleProvider struct {
+	config *oauth2.Config
+}
+
+//NewGoogleProvider returns a Provider for Google/YouTube Music
+func NewGoogleProvider(clientID, clientSecret, redirectURL string, scopes []string) Provider {
+	if len(scopes) == 0 {
+		scopes = defaultGoogleScopes
+	}
+	return &googleProvider{config: &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Endpoint:     google.Endpoint,
+		Scopes:       scopes,
+	}}
+}
+
+func (p *googleProvider) Name() string { return "google" }
+
+func (p *googleProvider) OAuth2Config() *oauth2.Config { return p.config }
+
+func (p *googleProvider) NewClient(token *oauth2.Token) UserClient {
+	return &googleUserClient{config: p.config, token: token}
+}
+
+type googleUserClient struct {
+	config *oauth2.Config
+	token  *oauth2.Token
+}
+
+func (c *googleUserClient) GetProfile(ctx context.Context) (*Profile, error) {
+	httpClient := c.config.Client(ctx, c.token)
+	resp, err := httpClient.Get(googleUserInfoURL)
+	if err != nil {
+		return nil, fmt.Errorf("providers: fetching google userinfo: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("providers: google userinfo returned %s", resp.Status)
+	}
+	var body struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("providers: decoding google userinfo: %w", err)
+	}
+	return &Profile{ID: body.ID, DisplayName: body.Name, Email: body.Email}, nil
+}
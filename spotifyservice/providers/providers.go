@@ -0,0 +1,66 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+// Package providers lets the app authenticate against more than one music
+// service's OAuth2 flow, so a user can link/unlink Spotify, Google, etc.
+// against one local account.
+package providers
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+)
+
+//Profile is the provider-agnostic subset of a linked account's profile we
+//care about
+type Profile struct {
+	ID          string
+	DisplayName string
+	Email       string
+}
+
+//UserClient calls a provider's API on behalf of one already-authenticated
+//user
+type UserClient interface {
+	GetProfile(ctx context.Context) (*Profile, error)
+}
+
+//Provider is a music service integrated via OAuth2
+type Provider interface {
+	//Name is this provider's key, used in its /auth/{name} routes and as
+	//part of the token store's lookup key
+	Name() string
+	OAuth2Config() *oauth2.Config
+	//NewClient returns a UserClient bound to token
+	NewClient(token *oauth2.Token) UserClient
+}
+
+//Registry holds the Providers configured for this server, keyed by name
+type Registry struct {
+	providers map[string]Provider
+}
+
+//NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{providers: map[string]Provider{}}
+}
+
+//Register adds p to the registry, keyed by p.Name()
+func (r *Registry) Register(p Provider) {
+	r.providers[p.Name()] = p
+}
+
+//Get returns the provider registered under name, if any
+func (r *Registry) Get(name string) (Provider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+//Each calls fn once for every registered provider
+func (r *Registry) Each(fn func(Provider)) {
+	for _, p := range r.providers {
+		fn(p)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package providers
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+
+	"github.com/schairez/spotifywork/spotifyservice/spotifyapi"
+)
+
+//spotifyProvider adapts spotifyapi.Client to the Provider interface
+type spotifyProvider struct {
+	client *spotifyapi.Client
+}
+
+//NewSpotifyProvider returns a Provider for Spotify
+func NewSpotifyProvider(clientID, clientSecret, redirectURL string, scopes []string) Provider {
+	return &spotifyProvider{client: spotifyapi.NewClient(clientID, clientSecret, redirectURL, scopes, 0, 0)}
+}
+
+func (p *spotifyProvider) Name() string { return "spotify" }
+
+func (p *spotifyProvider) OAuth2Config() *oauth2.Config { return p.client.Config }
+
+func (p *spotifyProvider) NewClient(token *oauth2.Token) UserClient {
+	return &spotifyUserClient{client: p.client, token: token}
+}
+
+type spotifyUserClient struct {
+	client *spotifyapi.Client
+	token  *oauth2.Token
+}
+
+func (c *spotifyUserClient) GetProfile(ctx context.Context) (*Profile, error) {
+	user, err := c.client.GetUserProfileRequest(ctx, c.token)
+	if err != nil {
+		return nil, err
+	}
+	return &Profile{ID: user.ID, DisplayName: user.DisplayName, Email: user.Email}, nil
+}
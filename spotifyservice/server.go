@@ -13,7 +13,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -21,7 +23,10 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/schairez/spotifywork/env"
 	"github.com/schairez/spotifywork/internal"
+	"github.com/schairez/spotifywork/spotifyservice/providers"
 	"github.com/schairez/spotifywork/spotifyservice/spotifyapi"
+	"github.com/schairez/spotifywork/spotifyservice/sync"
+	"golang.org/x/oauth2"
 )
 
 /*
@@ -31,7 +36,34 @@ import (
  https://chromium.googlesource.com/external/github.com/golang/oauth2/+/8f816d62a2652f705144857bbbcc26f2c166af9e/oauth2.go
 */
 
-const stateCookieName = "oauthState"
+const stateCookiePrefix = "oauthState_"
+const pkceVerifierCookiePrefix = "pkceVerifier_"
+const sessionCookiePrefix = "session_"
+const sessionTTL = 30 * 24 * time.Hour
+
+//sessionCookieName returns the name of the cookie that maps a browser to a
+//signed-in user for the given provider
+func sessionCookieName(provider string) string {
+	return sessionCookiePrefix + provider
+}
+
+//stateCookieName and pkceVerifierCookieName are namespaced per provider, like
+//sessionCookieName, so that two in-flight link flows (e.g. Spotify then
+//Google, back-to-back or in separate tabs) don't clobber each other's state
+//or PKCE verifier cookie
+func stateCookieName(provider string) string {
+	return stateCookiePrefix + provider
+}
+
+func pkceVerifierCookieName(provider string) string {
+	return pkceVerifierCookiePrefix + provider
+}
+
+//tokenKey namespaces the TokenStore lookup key by provider, since the same
+//local account can have a token linked per-provider
+func tokenKey(provider, userID string) string {
+	return provider + ":" + userID
+}
 
 func genRandState() string {
 	log.Println("generating rand bytes")
@@ -48,6 +80,10 @@ func genRandState() string {
 type Server struct {
 	cfg        *env.TomlConfig
 	client     *spotifyapi.Client
+	providers  *providers.Registry
+	tokenStore TokenStore
+	syncStore  *sync.Store
+	syncer     *sync.Syncer
 	router     *chi.Mux
 	httpServer *http.Server
 }
@@ -57,6 +93,9 @@ func NewServer(fileName string) *Server {
 	s := &Server{}
 	s.initCfg(fileName)
 	s.initClient()
+	s.initProviders()
+	s.initTokenStore()
+	s.initSync()
 	s.routes()
 	return s
 }
@@ -81,7 +120,172 @@ func (s *Server) initClient() {
 	s.client = spotifyapi.NewClient(
 		cfg.ClientID,
 		cfg.ClientSecret,
-		cfg.RedirectURL)
+		cfg.RedirectURL,
+		cfg.Scopes,
+		s.cfg.RateLimit.QPS,
+		s.cfg.RateLimit.MaxRetries)
+}
+
+//initProviders builds the registry of linkable music services from the
+//"spotify" and "google" entries of the TOML file, where present; entries
+//under any other provider name are not registered
+func (s *Server) initProviders() {
+	s.providers = providers.NewRegistry()
+	if cfg, ok := s.cfg.Oauth2Providers["spotify"]; ok {
+		s.providers.Register(providers.NewSpotifyProvider(cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, cfg.Scopes))
+	}
+	if cfg, ok := s.cfg.Oauth2Providers["google"]; ok {
+		s.providers.Register(providers.NewGoogleProvider(cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, cfg.Scopes))
+	}
+}
+
+func (s *Server) initTokenStore() {
+	store, err := newBoltTokenStore(s.cfg.TokenStore.Path, s.cfg.TokenStore.EncryptionKey)
+	if err != nil {
+		log.Fatalf("failed to init token store: %v", err)
+	}
+	s.tokenStore = store
+}
+
+func (s *Server) initSync() {
+	store, err := sync.Open(s.cfg.Sync.DBPath)
+	if err != nil {
+		log.Fatalf("failed to init sync store: %v", err)
+	}
+	s.syncStore = store
+	s.syncer = sync.NewSyncer(s.client, store, s.cfg.Sync.Workers, s.cfg.Sync.QPS)
+}
+
+//clientForRequest resolves the Spotify token for the user behind r's signed
+//session cookie, transparently refreshing it via oauth2.ReuseTokenSource and
+//persisting the refreshed token back to the TokenStore when it rotates
+func (s *Server) clientForRequest(r *http.Request) (*oauth2.Token, error) {
+	cookie, err := r.Cookie(sessionCookieName("spotify"))
+	if err != nil {
+		return nil, fmt.Errorf("no session cookie: %w", err)
+	}
+	userID, err := internal.Unsign(s.cfg.TokenStore.SessionSecret, cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session cookie: %w", err)
+	}
+	key := tokenKey("spotify", userID)
+	token, err := s.tokenStore.LoadToken(key)
+	if err != nil {
+		return nil, fmt.Errorf("loading token for %q: %w", userID, err)
+	}
+	//route the refresh exchange through the same rate-limited, retrying
+	//transport as every other Spotify request, instead of oauth2's fallback
+	//of http.DefaultClient
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, s.client.HTTPClient())
+	source := oauth2.ReuseTokenSource(token, s.client.Config.TokenSource(ctx, token))
+	refreshed, err := source.Token()
+	if err != nil {
+		return nil, fmt.Errorf("refreshing token for %q: %w", userID, err)
+	}
+	if refreshed.AccessToken != token.AccessToken {
+		if err := s.tokenStore.SaveToken(key, refreshed); err != nil {
+			log.Printf("failed to persist refreshed token for %q: %v", userID, err)
+		}
+	}
+	return refreshed, nil
+}
+
+//registerProviderRoutes wires up the /auth/{name} and /auth/{name}/callback
+//pair for p, following the Authorization Code + PKCE flow
+func (s *Server) registerProviderRoutes(p providers.Provider) {
+	name := p.Name()
+
+	s.router.Get("/auth/"+name, func(w http.ResponseWriter, r *http.Request) {
+		localState := genRandState()
+		//setting the set-Cookie header in the writer
+		//NOTE: headers need to be set before anything else set to the writer
+		http.SetCookie(w, internal.NewCookie(stateCookieName(name), localState))
+
+		//PKCE: stash a code_verifier in a short-lived cookie and send the
+		//provider its derived code_challenge, so the callback can prove it's
+		//the same client that started the flow even without a client secret
+		verifier, err := internal.GenerateCodeVerifier()
+		if err != nil {
+			log.Printf("failed to generate pkce code verifier: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, internal.NewCookie(pkceVerifierCookieName(name), verifier))
+		challenge := internal.CodeChallengeS256(verifier)
+
+		authURL := p.OAuth2Config().AuthCodeURL(localState,
+			oauth2.SetAuthURLParam("code_challenge", challenge),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+		//app directs user-agent to the provider's oauth2 auth consent page
+		http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+	})
+
+	//below we have our redirect callback as a result of a user-agent accessing
+	//the /auth/{name} route above
+	s.router.Get("/auth/"+name+"/callback", func(w http.ResponseWriter, r *http.Request) {
+		//check if user denied our auth request; the request we receive
+		//would contain a non-empty error query param in this case
+		if r.FormValue("error") != "" {
+			log.Printf("%s authorization failed. Reason=%s", name, r.FormValue("error"))
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+		//check the state parameter we supplied to the provider's auth service
+		//earlier; if the user approved the auth, we'll have both a code and a
+		//state query param
+		oauthStateCookie, err := r.Cookie(stateCookieName(name))
+		if err != nil {
+			log.Println("Error finding cookie: ", err.Error())
+			http.Redirect(w, r, "/", http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("state") != oauthStateCookie.Value {
+			log.Printf("invalid oauth2 %s state. state_mismatch err", name)
+			http.Redirect(w, r, "/", http.StatusUnauthorized)
+			return
+		}
+		//PKCE: the verifier cookie set in /auth/{name} must accompany the
+		//exchange so the provider can confirm it matches the code_challenge
+		//sent earlier
+		verifierCookie, err := r.Cookie(pkceVerifierCookieName(name))
+		if err != nil {
+			log.Println("Error finding pkce verifier cookie: ", err.Error())
+			http.Error(w, "missing pkce verifier", http.StatusBadRequest)
+			return
+		}
+		//exchange auth code with an access token
+		token, err := p.OAuth2Config().Exchange(r.Context(), r.FormValue("code"),
+			oauth2.SetAuthURLParam("code_verifier", verifierCookie.Value),
+		)
+		if err != nil {
+			log.Printf("error converting auth code into token; %s", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		//we'll use the token to access the user's protected resources
+		profile, err := p.NewClient(token).GetProfile(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("%s profile: %+v\n", name, profile)
+
+		//persist the token (encrypted at rest) so the user doesn't have to
+		//re-consent the next time the server restarts
+		key := tokenKey(name, profile.ID)
+		if err := s.tokenStore.SaveToken(key, token); err != nil {
+			log.Printf("failed to save token for %q: %v", key, err)
+			http.Error(w, "failed to persist session", http.StatusInternalServerError)
+			return
+		}
+		//issue a signed session cookie mapping this browser to the provider user ID
+		sessionValue := internal.Sign(s.cfg.TokenStore.SessionSecret, profile.ID)
+		http.SetCookie(w, internal.NewCookieWithTTL(sessionCookieName(name), sessionValue, sessionTTL))
+
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	})
 }
 
 //routes inits the route multiplexer with the assigned routes
@@ -117,124 +321,120 @@ func (s *Server) routes() {
 	filesDir := http.Dir(filepath.Join(workDir, "data"))
 	FileServer(s.router, "/templates", filesDir)
 
-	//account signin with Spotify
-	// s.router.Get("/accounts/signup")
-
-	s.router.Get("/auth", func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		log.Println(ctx)
-		//check if the request contains a cookie?
-		//COOKIE would be attached if the use has hit our domain
-		//this would indicate that a user-agent has hit this endpoint, but not
-		//that the user has authorized our app per-say
-		log.Println("checking if user already has a cookie stored in their browser")
-		cookie, err := r.Cookie(stateCookieName)
-		if err != nil {
-			log.Printf("we got no cookie in request, %s", err)
-		}
-		fmt.Println(cookie)
-		localState := genRandState()
-		//setting the set-Cookie header in the writer
-		//NOTE: headers need to be set before anything else set to the writer
-		http.SetCookie(w, internal.NewCookie(stateCookieName, localState))
-		fmt.Println(localState)
-		fmt.Println(w.Header())
-		authURL := s.client.Config.AuthCodeURL(localState)
-		//app directs user-agent to spotify's oauth2 auth  consent page
-		http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
-
+	//account signin, one /auth/{provider} + /auth/{provider}/callback pair
+	//per linked music service
+	s.providers.Each(func(p providers.Provider) {
+		s.registerProviderRoutes(p)
 	})
-	//below we have our redirect callback as a result of a user-agent accessing
-	//our /auth endpoint route
-	s.router.Get("/auth/callback", func(w http.ResponseWriter, r *http.Request) {
-		//check if user denied our auth request the request we receive
-		//would contain a non-empty error query param in this case
-		if r.FormValue("error") != "" {
-			log.Printf("user authorization failed. Reason=%s", r.FormValue("error"))
-			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+
+	//returns the signed-in user's saved tracks, refreshing their token from
+	//the TokenStore if it has expired
+	s.router.Get("/me/tracks", func(w http.ResponseWriter, r *http.Request) {
+		token, err := s.clientForRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-		//check the state parameter we supplied to Spotify's Account's Service earlier
-		//if user approved the auth, we'll have both a code and a state query param
-		oauthStateCookie, err := r.Cookie(stateCookieName)
+		limit := 50
+		offset := 0
+		market := "us"
+		params := spotifyapi.QParams{Limit: &limit, Offset: &offset, Market: &market}
+		tracks, err := s.client.GetUserSavedTracks(r.Context(), token, &params)
 		if err != nil {
-			if err == http.ErrNoCookie {
-				log.Println("Error finding cookie: ", err.Error())
-				http.Redirect(w, r, "/", http.StatusUnauthorized)
-			}
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		log.Printf("%s=%s\r\n", oauthStateCookie.Name, oauthStateCookie.Value)
-		if r.FormValue("state") != oauthStateCookie.Value {
-			log.Println("invalid oauth2 spotify state. state_mismatch err")
-			//http.Error(w, "state_mismatch err", http.StatusUnauthorized)
-			http.Redirect(w, r, "/", http.StatusUnauthorized)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tracks)
+	})
 
+	//kicks off a full library sync (saved tracks, saved albums, followed
+	//artists, all playlists and their tracks) for the signed-in user. Passing
+	//?job_id=<id> of a previous job for this user resumes it from its
+	//persisted cursors instead of starting a new job from scratch
+	s.router.Post("/sync", func(w http.ResponseWriter, r *http.Request) {
+		token, err := s.clientForRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-		//TODO: pkce opts?
-		authCode := r.FormValue("code")
-		log.Printf("code=%s", authCode)
-		//TODO: diff b/w background and oauth2.NoContext
-		ctx := context.Background()
-		//exchange auth code with an access token
-		token, err := s.client.Config.Exchange(ctx, authCode)
-
+		cookie, _ := r.Cookie(sessionCookieName("spotify"))
+		userID, err := internal.Unsign(s.cfg.TokenStore.SessionSecret, cookie.Value)
 		if err != nil {
-			log.Printf("error converting auth code into token; %s", err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			// http.Error(w, err.Error(), http.StatusInternalServerError)
-			//TODO:
-			// or StatusForbidden?
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-		//we'll use the token to access user's protected resources
-		// by calling the Spotify Web API
-
-		log.Println(token)
-		log.Println("query params?")
-		queryParams := r.URL.Query()
-		log.Println(queryParams)
-		if reqHeadersBytes, err := json.Marshal(r.Header); err != nil {
-			log.Println("Could not Marshal Req Headers")
-		} else {
-			log.Println(string(reqHeadersBytes))
+		resumeJobID := r.URL.Query().Get("job_id")
+		jobID, err := s.syncer.StartJob(userID, token, resumeJobID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"job_id": jobID})
+	})
 
-		//now we can use this token to call Spotify APIs on behalf of the user
-		//use the token to get an authenticated client
-		//the underlying transport obtained using ctx?
-		user, err := s.client.GetUserProfileRequest(context.Background(), token)
+	//reports a sync job's status and progress, for the job's own owner only
+	s.router.Get("/sync/{id}", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookieName("spotify"))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-		log.Println("getting user")
-		log.Printf("%+v\n", user)
-
-		// data, _ := ioutil.ReadAll(resp.Body)
-		// log.Println("Data calling user API: ", string(data))
-		limit := 50
-		offset := 0
-		market := "us"
-		params := spotifyapi.QParams{Limit: &limit, Offset: &offset, Market: &market}
-		tracks, err := s.client.GetUserSavedTracks(context.Background(), token, &params)
+		userID, err := internal.Unsign(s.cfg.TokenStore.SessionSecret, cookie.Value)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-		log.Println("getting user tracks")
-		b, err := json.MarshalIndent(*tracks, "", "  ")
+		job, err := s.syncStore.GetJob(chi.URLParam(r, "id"))
 		if err != nil {
-			fmt.Println(err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
 		}
-		fmt.Print(string(b))
-		// log.Printf("%+v\n", tracks)
+		if job.UserID != userID {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	})
 
+	//exports the signed-in user's saved tracks as json, csv, or m3u
+	s.router.Get("/export", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookieName("spotify"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		userID, err := internal.Unsign(s.cfg.TokenStore.SessionSecret, cookie.Value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		tracks, err := s.syncStore.SavedTracksForExport(userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		format := sync.Format(r.URL.Query().Get("format"))
+		if err := sync.WriteExport(w, format, tracks); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	})
 
+	//unlinks a single provider: invalidates its stored token and clears its
+	//session cookie, leaving any other linked providers untouched
 	s.router.Get("/logout/{provider}", func(w http.ResponseWriter, r *http.Request) {
+		provider := chi.URLParam(r, "provider")
+		if cookie, err := r.Cookie(sessionCookieName(provider)); err == nil {
+			if userID, err := internal.Unsign(s.cfg.TokenStore.SessionSecret, cookie.Value); err == nil {
+				if err := s.tokenStore.DeleteToken(tokenKey(provider, userID)); err != nil {
+					log.Printf("failed to delete token for %q: %v", tokenKey(provider, userID), err)
+				}
+			}
+			http.SetCookie(w, internal.NewCookieWithTTL(sessionCookieName(provider), "", -sessionTTL))
+		}
 
 		w.Header().Set("Location", "/")
 		w.WriteHeader(http.StatusTemporaryRedirect)
@@ -287,8 +487,12 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.router.ServeHTTP(w, r)
 }
 
-//Start starts the server
-func (s *Server) Start() {
+const defaultShutdownTimeout = 10 * time.Second
+
+//Start starts the server and blocks until it receives SIGINT/SIGTERM, at
+//which point it drains in-flight requests before returning. Any
+//ListenAndServe or shutdown error is returned to the caller
+func (s *Server) Start() error {
 	s.httpServer = &http.Server{
 		Addr:         ":" + s.cfg.Server.Port,
 		Handler:      s.router,
@@ -296,19 +500,74 @@ func (s *Server) Start() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	log.Printf("server listening on %s\n", s.cfg.Server.Port)
-	if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
-		log.Fatalf("ListenAndServe err: %s", err)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	} else {
-		log.Println("Server closed!")
+	serveErrCh := make(chan error, 1)
+	go func() {
+		log.Printf("server listening on %s\n", s.cfg.Server.Port)
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serveErrCh <- fmt.Errorf("ListenAndServe: %w", err)
+			return
+		}
+		serveErrCh <- nil
+	}()
+
+	select {
+	case err := <-serveErrCh:
+		return err
+	case <-ctx.Done():
+		stop()
+		log.Println("shutdown signal received, draining in-flight requests")
 	}
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout())
+	defer cancel()
+	return s.Shutdown(shutdownCtx)
 }
 
-//Shutdown the server
-func (s *Server) Shutdown() {
+func (s *Server) shutdownTimeout() time.Duration {
+	if s.cfg.Server.ShutdownTimeoutSeconds <= 0 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(s.cfg.Server.ShutdownTimeoutSeconds) * time.Second
+}
 
+//Shutdown gracefully stops the HTTP server, waiting up to ctx's deadline for
+//in-flight requests to finish, then closes the token store and any pooled
+//HTTP transports
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	if s.httpServer != nil {
+		if shutErr := s.httpServer.Shutdown(ctx); shutErr != nil {
+			err = fmt.Errorf("shutting down http server: %w", shutErr)
+		}
+	}
+	if s.tokenStore != nil {
+		if closeErr := s.tokenStore.Close(); closeErr != nil {
+			closeErr = fmt.Errorf("closing token store: %w", closeErr)
+			if err != nil {
+				err = fmt.Errorf("%w; %s", err, closeErr)
+			} else {
+				err = closeErr
+			}
+		}
+	}
+	if s.syncStore != nil {
+		if closeErr := s.syncStore.Close(); closeErr != nil {
+			closeErr = fmt.Errorf("closing sync store: %w", closeErr)
+			if err != nil {
+				err = fmt.Errorf("%w; %s", err, closeErr)
+			} else {
+				err = closeErr
+			}
+		}
+	}
+	if s.client != nil {
+		s.client.Close()
+	}
+	log.Println("Server closed!")
+	return err
 }
 
 /*
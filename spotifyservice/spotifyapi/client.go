@@ -0,0 +1,190 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+// Package spotifyapi is a thin client for the parts of the Spotify Web API
+// this app needs.
+package spotifyapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	authURL    = "https://accounts.spotify.com/authorize"
+	tokenURL   = "https://accounts.spotify.com/api/token"
+	apiBaseURL = "https://api.spotify.com/v1"
+)
+
+//defaultScopes is used when the operator hasn't configured any scopes
+var defaultScopes = []string{"user-read-private", "user-library-read"}
+
+//Client wraps an oauth2.Config for the Spotify Accounts Service along with an
+//http.Client used to hit the Web API
+type Client struct {
+	Config     *oauth2.Config
+	httpClient *http.Client
+}
+
+//NewClient returns a Client configured against Spotify's OAuth2 endpoints. If
+//scopes is empty, defaultScopes is used. If qps is positive, it overrides the
+//rate-limited transport's default steady-state requests/sec; if maxRetries
+//is positive, it overrides the transport's default retry count
+func NewClient(clientID, clientSecret, redirectURL string, scopes []string, qps float64, maxRetries int) *Client {
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+	var opts []Option
+	if qps > 0 {
+		opts = append(opts, WithQPS(qps))
+	}
+	if maxRetries > 0 {
+		opts = append(opts, WithMaxRetries(maxRetries))
+	}
+	return &Client{
+		Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  authURL,
+				TokenURL: tokenURL,
+			},
+			Scopes: scopes,
+		},
+		httpClient: &http.Client{Transport: NewRateLimitedTransport(http.DefaultTransport, opts...)},
+	}
+}
+
+//Close releases any pooled connections held by the client's transport
+func (c *Client) Close() {
+	c.httpClient.CloseIdleConnections()
+}
+
+//HTTPClient returns the client's rate-limited, retrying http.Client. Callers
+//building their own oauth2.TokenSource (e.g. for a token refresh) must set
+//it via context.WithValue(ctx, oauth2.HTTPClient, c.HTTPClient()) so refresh
+//requests go through the same transport as everything else
+func (c *Client) HTTPClient() *http.Client {
+	return c.httpClient
+}
+
+//Metrics returns the client's rate-limited transport's retry/throttling
+//counters, for operators to scrape
+func (c *Client) Metrics() Metrics {
+	if t, ok := c.httpClient.Transport.(*rateLimitedTransport); ok {
+		return t.Metrics()
+	}
+	return Metrics{}
+}
+
+//Tokens reports the client's rate-limited transport's current token count
+func (c *Client) Tokens() float64 {
+	if t, ok := c.httpClient.Transport.(*rateLimitedTransport); ok {
+		return t.Tokens()
+	}
+	return 0
+}
+
+//User is the subset of a Spotify user's profile we care about
+type User struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	Email       string `json:"email"`
+}
+
+//QParams are the common paging/market query params accepted by the library
+//endpoints. After is used instead of Offset by the cursor-paged endpoints
+//(e.g. followed artists)
+type QParams struct {
+	Limit  *int
+	Offset *int
+	Market *string
+	After  *string
+}
+
+func (p *QParams) values() url.Values {
+	v := url.Values{}
+	if p == nil {
+		return v
+	}
+	if p.Limit != nil {
+		v.Set("limit", strconv.Itoa(*p.Limit))
+	}
+	if p.Offset != nil {
+		v.Set("offset", strconv.Itoa(*p.Offset))
+	}
+	if p.Market != nil {
+		v.Set("market", *p.Market)
+	}
+	if p.After != nil {
+		v.Set("after", *p.After)
+	}
+	return v
+}
+
+//SavedTrack is a single entry in a user's saved tracks library
+type SavedTrack struct {
+	AddedAt string `json:"added_at"`
+	Track   struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"track"`
+}
+
+//SavedTracksResponse is the paged response from GET /v1/me/tracks
+type SavedTracksResponse struct {
+	Items []SavedTrack `json:"items"`
+	Next  string       `json:"next"`
+	Total int          `json:"total"`
+}
+
+func (c *Client) get(ctx context.Context, token *oauth2.Token, path string, query url.Values, out interface{}) error {
+	//route token refreshes and the request itself through our rate-limited
+	//transport, not the bare http.DefaultClient oauth2 would otherwise use
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c.httpClient)
+	httpClient := c.Config.Client(ctx, token)
+	reqURL := apiBaseURL + path
+	if encoded := query.Encode(); encoded != "" {
+		reqURL += "?" + encoded
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("spotifyapi: building request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("spotifyapi: requesting %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("spotifyapi: %s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+//GetUserProfileRequest fetches the authenticated user's profile via GET /v1/me
+func (c *Client) GetUserProfileRequest(ctx context.Context, token *oauth2.Token) (*User, error) {
+	var user User
+	if err := c.get(ctx, token, "/me", nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+//GetUserSavedTracks fetches a page of the authenticated user's saved tracks
+//via GET /v1/me/tracks
+func (c *Client) GetUserSavedTracks(ctx context.Context, token *oauth2.Token, params *QParams) (*SavedTracksResponse, error) {
+	var tracks SavedTracksResponse
+	if err := c.get(ctx, token, "/me/tracks", params.values(), &tracks); err != nil {
+		return nil, err
+	}
+	return &tracks, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package spotifyapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+//SavedAlbum is a single entry in a user's saved albums library
+type SavedAlbum struct {
+	AddedAt string `json:"added_at"`
+	Album   struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"album"`
+}
+
+//SavedAlbumsResponse is the paged response from GET /v1/me/albums
+type SavedAlbumsResponse struct {
+	Items []SavedAlbum `json:"items"`
+	Next  string       `json:"next"`
+	Total int          `json:"total"`
+}
+
+//GetUserSavedAlbums fetches a page of the authenticated user's saved albums
+//via GET /v1/me/albums
+func (c *Client) GetUserSavedAlbums(ctx context.Context, token *oauth2.Token, params *QParams) (*SavedAlbumsResponse, error) {
+	var albums SavedAlbumsResponse
+	if err := c.get(ctx, token, "/me/albums", params.values(), &albums); err != nil {
+		return nil, err
+	}
+	return &albums, nil
+}
+
+//Artist is a single followed artist
+type Artist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+//followedArtistsResponse mirrors the "cursor-based paging object" wrapper
+//Spotify puts around GET /v1/me/following
+type followedArtistsResponse struct {
+	Artists struct {
+		Items  []Artist `json:"items"`
+		Next   string   `json:"next"`
+		Cursor struct {
+			After string `json:"after"`
+		} `json:"cursors"`
+		Total int `json:"total"`
+	} `json:"artists"`
+}
+
+//FollowedArtistsResponse is a page of the authenticated user's followed
+//artists, flattened from Spotify's nested cursor-paging object
+type FollowedArtistsResponse struct {
+	Items      []Artist
+	Next       string
+	NextCursor string
+	Total      int
+}
+
+//GetFollowedArtists fetches a page of the authenticated user's followed
+//artists via GET /v1/me/following?type=artist. Pages are cursor-based:
+//params.After should be set to the previous page's NextCursor
+func (c *Client) GetFollowedArtists(ctx context.Context, token *oauth2.Token, params *QParams) (*FollowedArtistsResponse, error) {
+	values := params.values()
+	values.Set("type", "artist")
+	var resp followedArtistsResponse
+	if err := c.get(ctx, token, "/me/following", values, &resp); err != nil {
+		return nil, err
+	}
+	return &FollowedArtistsResponse{
+		Items:      resp.Artists.Items,
+		Next:       resp.Artists.Next,
+		NextCursor: resp.Artists.Cursor.After,
+		Total:      resp.Artists.Total,
+	}, nil
+}
+
+//Playlist is the subset of a playlist's metadata needed to sync its tracks
+type Playlist struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	SnapshotID  string `json:"snapshot_id"`
+	TracksTotal int    `json:"tracks_total"`
+}
+
+type playlist struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	SnapshotID string `json:"snapshot_id"`
+	Tracks     struct {
+		Total int `json:"total"`
+	} `json:"tracks"`
+}
+
+//PlaylistsResponse is the paged response from GET /v1/me/playlists
+type PlaylistsResponse struct {
+	Items []Playlist `json:"-"`
+	Next  string     `json:"next"`
+	Total int        `json:"total"`
+}
+
+//UnmarshalJSON flattens Spotify's nested tracks.total into Playlist.TracksTotal
+func (r *PlaylistsResponse) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Items []playlist `json:"items"`
+		Next  string     `json:"next"`
+		Total int        `json:"total"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	r.Next, r.Total = raw.Next, raw.Total
+	r.Items = make([]Playlist, len(raw.Items))
+	for i, p := range raw.Items {
+		r.Items[i] = Playlist{ID: p.ID, Name: p.Name, SnapshotID: p.SnapshotID, TracksTotal: p.Tracks.Total}
+	}
+	return nil
+}
+
+//GetUserPlaylists fetches a page of the authenticated user's playlists via
+//GET /v1/me/playlists
+func (c *Client) GetUserPlaylists(ctx context.Context, token *oauth2.Token, params *QParams) (*PlaylistsResponse, error) {
+	var playlists PlaylistsResponse
+	if err := c.get(ctx, token, "/me/playlists", params.values(), &playlists); err != nil {
+		return nil, err
+	}
+	return &playlists, nil
+}
+
+//PlaylistTrack is a single entry in a playlist
+type PlaylistTrack struct {
+	AddedAt string `json:"added_at"`
+	Track   struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"track"`
+}
+
+//PlaylistTracksResponse is the paged response from GET /v1/playlists/{id}/tracks
+type PlaylistTracksResponse struct {
+	Items []PlaylistTrack `json:"items"`
+	Next  string          `json:"next"`
+	Total int             `json:"total"`
+}
+
+//GetPlaylistTracks fetches a page of playlistID's tracks via
+//GET /v1/playlists/{id}/tracks
+func (c *Client) GetPlaylistTracks(ctx context.Context, token *oauth2.Token, playlistID string, params *QParams) (*PlaylistTracksResponse, error) {
+	var tracks PlaylistTracksResponse
+	if err := c.get(ctx, token, fmt.Sprintf("/playlists/%s/tracks", playlistID), params.values(), &tracks); err != nil {
+		return nil, err
+	}
+	return &tracks, nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package spotifyapi
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultQPS        = 10
+	defaultMaxRetries = 5
+	defaultJitter     = 250 * time.Millisecond
+)
+
+//Metrics are the counters an operator can scrape to see throttling behavior
+type Metrics struct {
+	Retries         uint64
+	TooManyRequests uint64
+}
+
+//Snapshot returns a point-in-time copy of m, safe to read concurrently with
+//the transport updating it
+func (m *Metrics) Snapshot() Metrics {
+	return Metrics{
+		Retries:         atomic.LoadUint64(&m.Retries),
+		TooManyRequests: atomic.LoadUint64(&m.TooManyRequests),
+	}
+}
+
+//Option configures a rate-limited transport
+type Option func(*rateLimitedTransport)
+
+//WithQPS sets the steady-state requests/sec the client-side limiter allows
+func WithQPS(qps float64) Option {
+	return func(t *rateLimitedTransport) {
+		t.limiter = rate.NewLimiter(rate.Limit(qps), burstFor(qps))
+	}
+}
+
+//WithMaxRetries caps how many times a 429/502/503/504 response is retried
+//before it's returned to the caller
+func WithMaxRetries(n int) Option {
+	return func(t *rateLimitedTransport) { t.maxRetries = n }
+}
+
+//WithMetrics attaches m so the caller can observe retry/throttling counters
+func WithMetrics(m *Metrics) Option {
+	return func(t *rateLimitedTransport) { t.metrics = m }
+}
+
+func burstFor(qps float64) int {
+	return int(math.Max(1, qps))
+}
+
+type qpsOverrideKey struct{}
+
+//WithQPSOverride returns a context that, for requests made with it, replaces
+//the transport's steady-state limiter with one at qps. The limiter is
+//created once and stored on the context, so every request derived from the
+//returned context (e.g. every page of a bulk pagination loop) shares and
+//throttles against the same bucket instead of each getting a fresh, fully
+//topped-up one. Callers doing bulk pagination (e.g. walking
+//GetUserSavedTracks across hundreds of pages) can use this to tune their own
+//concurrency without affecting other requests
+func WithQPSOverride(ctx context.Context, qps float64) context.Context {
+	return context.WithValue(ctx, qpsOverrideKey{}, rate.NewLimiter(rate.Limit(qps), burstFor(qps)))
+}
+
+//rateLimitedTransport is an http.RoundTripper middleware that enforces a
+//client-side token-bucket limiter and retries 429/502/503/504 responses
+type rateLimitedTransport struct {
+	base       http.RoundTripper
+	limiter    *rate.Limiter
+	maxRetries int
+	metrics    *Metrics
+}
+
+//NewRateLimitedTransport wraps base with a client-side token-bucket limiter
+//(to stay under Spotify's rolling-window quota) plus retries: 429s honor the
+//Retry-After header, 502/503/504s back off exponentially with jitter
+func NewRateLimitedTransport(base http.RoundTripper, opts ...Option) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &rateLimitedTransport{
+		base:       base,
+		limiter:    rate.NewLimiter(rate.Limit(defaultQPS), burstFor(defaultQPS)),
+		maxRetries: defaultMaxRetries,
+		metrics:    &Metrics{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+//Tokens reports the limiter's current token count
+func (t *rateLimitedTransport) Tokens() float64 {
+	return t.limiter.Tokens()
+}
+
+//Metrics returns the retry/throttling counters tracked by this transport
+func (t *rateLimitedTransport) Metrics() Metrics {
+	return t.metrics.Snapshot()
+}
+
+//CloseIdleConnections closes the idle connections pooled by the base
+//transport, if it supports doing so
+func (t *rateLimitedTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiter := t.limiter
+	if override, ok := req.Context().Value(qpsOverrideKey{}).(*rate.Limiter); ok {
+		limiter = override
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt <= t.maxRetries; attempt++ {
+		if waitErr := limiter.Wait(req.Context()); waitErr != nil {
+			return nil, waitErr
+		}
+		resp, err = t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		if attempt == t.maxRetries || !shouldRetry(resp.StatusCode) {
+			return resp, nil
+		}
+		//req.Body was drained and closed by the RoundTrip above; rewind it via
+		//GetBody before resending, or give up retrying if it can't be rewound
+		var freshBody io.ReadCloser
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			if freshBody, err = req.GetBody(); err != nil {
+				return resp, nil
+			}
+		}
+		atomic.AddUint64(&t.metrics.Retries, 1)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			atomic.AddUint64(&t.metrics.TooManyRequests, 1)
+		}
+		delay := retryDelay(resp, attempt)
+		resp.Body.Close()
+		if freshBody != nil {
+			req.Body = freshBody
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	return resp, err
+}
+
+func shouldRetry(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+//retryDelay honors Retry-After on 429s; otherwise it backs off exponentially
+//with jitter based on the retry attempt number
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(secs)*time.Second + jitter(defaultJitter)
+		}
+	}
+	backoff := time.Duration(1<<uint(attempt)) * defaultJitter
+	return backoff + jitter(backoff/2)
+}
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package spotifyapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusNotFound, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusGatewayTimeout, true},
+		{http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		if got := shouldRetry(tt.status); got != tt.want {
+			t.Errorf("shouldRetry(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelayHonorsRetryAfter(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"2"}},
+	}
+	delay := retryDelay(resp, 0)
+	min, max := 2*time.Second, 2*time.Second+defaultJitter
+	if delay < min || delay >= max {
+		t.Errorf("retryDelay with Retry-After=2 = %v, want in [%v, %v)", delay, min, max)
+	}
+}
+
+func TestRetryDelayFallsBackToExponentialOnMissingRetryAfter(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	delay := retryDelay(resp, 0)
+	min, max := defaultJitter, defaultJitter+defaultJitter/2
+	if delay < min || delay >= max {
+		t.Errorf("retryDelay with no Retry-After = %v, want in [%v, %v)", delay, min, max)
+	}
+}
+
+func TestRetryDelayBacksOffExponentially(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		minDelay time.Duration
+		maxDelay time.Duration
+	}{
+		{0, defaultJitter, defaultJitter + defaultJitter/2},
+		{1, 2 * defaultJitter, 2*defaultJitter + defaultJitter},
+		{2, 4 * defaultJitter, 4*defaultJitter + 2*defaultJitter},
+	}
+	resp := &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{}}
+	for _, tt := range tests {
+		delay := retryDelay(resp, tt.attempt)
+		if delay < tt.minDelay || delay >= tt.maxDelay {
+			t.Errorf("retryDelay(attempt=%d) = %v, want in [%v, %v)", tt.attempt, delay, tt.minDelay, tt.maxDelay)
+		}
+	}
+}
+
+func TestJitterIsBounded(t *testing.T) {
+	if got := jitter(0); got != 0 {
+		t.Errorf("jitter(0) = %v, want 0", got)
+	}
+	for i := 0; i < 100; i++ {
+		if got := jitter(100 * time.Millisecond); got < 0 || got >= 100*time.Millisecond {
+			t.Fatalf("jitter(100ms) = %v, want in [0, 100ms)", got)
+		}
+	}
+}
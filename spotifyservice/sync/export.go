@@ -0,0 +1,93 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package sync
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+//Format is an export file format for a user's saved tracks
+type Format string
+
+//Supported export formats
+const (
+	FormatJSON Format = "json"
+	FormatCSV  Format = "csv"
+	FormatM3U  Format = "m3u"
+)
+
+//ExportedTrack is one row of a user's saved-tracks export
+type ExportedTrack struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	AddedAt string `json:"added_at"`
+}
+
+//SavedTracksForExport returns userID's saved tracks ordered by when they
+//were added, for use with WriteExport
+func (s *Store) SavedTracksForExport(userID string) ([]ExportedTrack, error) {
+	rows, err := s.db.Query(
+		`SELECT t.id, t.name, st.added_at
+		 FROM saved_tracks st JOIN tracks t ON t.id = st.track_id
+		 WHERE st.user_id = ?
+		 ORDER BY st.added_at`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("sync: querying saved tracks for export: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []ExportedTrack
+	for rows.Next() {
+		var t ExportedTrack
+		if err := rows.Scan(&t.ID, &t.Name, &t.AddedAt); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+//WriteExport writes tracks to w in the given format
+func WriteExport(w io.Writer, format Format, tracks []ExportedTrack) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, tracks)
+	case FormatM3U:
+		return writeM3U(w, tracks)
+	case FormatJSON, "":
+		return json.NewEncoder(w).Encode(tracks)
+	default:
+		return fmt.Errorf("sync: unsupported export format %q", format)
+	}
+}
+
+func writeCSV(w io.Writer, tracks []ExportedTrack) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "name", "added_at"}); err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		if err := cw.Write([]string{t.ID, t.Name, t.AddedAt}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeM3U(w io.Writer, tracks []ExportedTrack) error {
+	if _, err := fmt.Fprintln(w, "#EXTM3U"); err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		if _, err := fmt.Fprintf(w, "#EXTINF:-1,%s\nspotify:track:%s\n", t.Name, t.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+// Package sync performs a full Spotify library sync (saved tracks, saved
+// albums, followed artists, and all playlists with their tracks) into a
+// normalized SQLite schema, resumable page-by-page via persisted cursors.
+package sync
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS tracks (
+	id   TEXT PRIMARY KEY,
+	name TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS albums (
+	id   TEXT PRIMARY KEY,
+	name TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS artists (
+	id   TEXT PRIMARY KEY,
+	name TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS playlists (
+	id          TEXT PRIMARY KEY,
+	name        TEXT NOT NULL,
+	snapshot_id TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS saved_tracks (
+	user_id  TEXT NOT NULL,
+	track_id TEXT NOT NULL,
+	added_at TEXT,
+	PRIMARY KEY (user_id, track_id)
+);
+CREATE TABLE IF NOT EXISTS saved_albums (
+	user_id  TEXT NOT NULL,
+	album_id TEXT NOT NULL,
+	added_at TEXT,
+	PRIMARY KEY (user_id, album_id)
+);
+CREATE TABLE IF NOT EXISTS followed_artists (
+	user_id   TEXT NOT NULL,
+	artist_id TEXT NOT NULL,
+	PRIMARY KEY (user_id, artist_id)
+);
+CREATE TABLE IF NOT EXISTS playlist_tracks (
+	playlist_id TEXT NOT NULL,
+	track_id    TEXT NOT NULL,
+	added_at    TEXT,
+	PRIMARY KEY (playlist_id, track_id)
+);
+CREATE TABLE IF NOT EXISTS sync_jobs (
+	id          TEXT PRIMARY KEY,
+	user_id     TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	done_pages  INTEGER NOT NULL DEFAULT 0,
+	error       TEXT NOT NULL DEFAULT '',
+	created_at  TEXT NOT NULL,
+	updated_at  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sync_cursors (
+	job_id      TEXT NOT NULL,
+	endpoint    TEXT NOT NULL,
+	offset      INTEGER NOT NULL DEFAULT 0,
+	snapshot_id TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (job_id, endpoint)
+);
+`
+
+//Status is the lifecycle state of a Job
+type Status string
+
+//Job lifecycle states
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+//Job tracks the progress of one full library sync
+type Job struct {
+	ID        string
+	UserID    string
+	Status    Status
+	DonePages int
+	Error     string
+}
+
+//Store is the SQLite-backed persistence layer for synced library data, job
+//status, and per-endpoint resume cursors
+type Store struct {
+	db *sql.DB
+}
+
+//Open opens (creating if needed) a SQLite database at path and migrates it
+//to the current schema
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("sync: opening %q: %w", path, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sync: migrating schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+//Close closes the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("sync: generating job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//CreateJob inserts a new pending Job for userID
+func (s *Store) CreateJob(userID string) (*Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err = s.db.Exec(
+		`INSERT INTO sync_jobs (id, user_id, status, done_pages, error, created_at, updated_at)
+		 VALUES (?, ?, ?, 0, '', ?, ?)`,
+		id, userID, StatusPending, now, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sync: creating job: %w", err)
+	}
+	return &Job{ID: id, UserID: userID, Status: StatusPending}, nil
+}
+
+//GetJob returns the job with id, or sql.ErrNoRows if it doesn't exist
+func (s *Store) GetJob(id string) (*Job, error) {
+	var job Job
+	row := s.db.QueryRow(
+		`SELECT id, user_id, status, done_pages, error FROM sync_jobs WHERE id = ?`, id)
+	if err := row.Scan(&job.ID, &job.UserID, &job.Status, &job.DonePages, &job.Error); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+//SetJobStatus transitions job id to status, recording errMsg if it failed
+func (s *Store) SetJobStatus(id string, status Status, errMsg string) error {
+	_, err := s.db.Exec(
+		`UPDATE sync_jobs SET status = ?, error = ?, updated_at = ? WHERE id = ?`,
+		status, errMsg, time.Now().UTC().Format(time.RFC3339), id,
+	)
+	return err
+}
+
+//IncrementJobProgress bumps job id's done_pages counter by one
+func (s *Store) IncrementJobProgress(id string) error {
+	_, err := s.db.Exec(
+		`UPDATE sync_jobs SET done_pages = done_pages + 1, updated_at = ? WHERE id = ?`,
+		time.Now().UTC().Format(time.RFC3339), id,
+	)
+	return err
+}
+
+//LoadCursor returns the last-saved (offset, snapshotID) for jobID+endpoint,
+//or (0, "", nil) if no page of that endpoint has been synced yet
+func (s *Store) LoadCursor(jobID, endpoint string) (offset int, snapshotID string, err error) {
+	row := s.db.QueryRow(
+		`SELECT offset, snapshot_id FROM sync_cursors WHERE job_id = ? AND endpoint = ?`, jobID, endpoint)
+	err = row.Scan(&offset, &snapshotID)
+	if err == sql.ErrNoRows {
+		return 0, "", nil
+	}
+	return offset, snapshotID, err
+}
+
+//SaveCursor persists the resume point for jobID+endpoint so a crash can pick
+//up where it left off
+func (s *Store) SaveCursor(jobID, endpoint string, offset int, snapshotID string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO sync_cursors (job_id, endpoint, offset, snapshot_id) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (job_id, endpoint) DO UPDATE SET offset = excluded.offset, snapshot_id = excluded.snapshot_id`,
+		jobID, endpoint, offset, snapshotID,
+	)
+	return err
+}
+
+//UpsertTrack inserts or updates a track's name
+func (s *Store) UpsertTrack(id, name string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO tracks (id, name) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET name = excluded.name`, id, name)
+	return err
+}
+
+//UpsertAlbum inserts or updates an album's name
+func (s *Store) UpsertAlbum(id, name string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO albums (id, name) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET name = excluded.name`, id, name)
+	return err
+}
+
+//UpsertArtist inserts or updates an artist's name
+func (s *Store) UpsertArtist(id, name string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO artists (id, name) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET name = excluded.name`, id, name)
+	return err
+}
+
+//UpsertSavedTrack records that userID has track_id saved
+func (s *Store) UpsertSavedTrack(userID, trackID, addedAt string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO saved_tracks (user_id, track_id, added_at) VALUES (?, ?, ?)
+		 ON CONFLICT (user_id, track_id) DO UPDATE SET added_at = excluded.added_at`, userID, trackID, addedAt)
+	return err
+}
+
+//UpsertSavedAlbum records that userID has album_id saved
+func (s *Store) UpsertSavedAlbum(userID, albumID, addedAt string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO saved_albums (user_id, album_id, added_at) VALUES (?, ?, ?)
+		 ON CONFLICT (user_id, album_id) DO UPDATE SET added_at = excluded.added_at`, userID, albumID, addedAt)
+	return err
+}
+
+//UpsertFollowedArtist records that userID follows artist_id
+func (s *Store) UpsertFollowedArtist(userID, artistID string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO followed_artists (user_id, artist_id) VALUES (?, ?) ON CONFLICT (user_id, artist_id) DO NOTHING`,
+		userID, artistID)
+	return err
+}
+
+//UpsertPlaylistIfChanged upserts playlist id's metadata and reports whether
+//its snapshot_id differs from what was previously stored, so the caller
+//knows whether an incremental re-sync of its tracks is needed
+func (s *Store) UpsertPlaylistIfChanged(id, name, snapshotID string) (changed bool, err error) {
+	var previous string
+	row := s.db.QueryRow(`SELECT snapshot_id FROM playlists WHERE id = ?`, id)
+	switch err := row.Scan(&previous); err {
+	case nil:
+		changed = previous != snapshotID
+	case sql.ErrNoRows:
+		changed = true
+	default:
+		return false, err
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO playlists (id, name, snapshot_id) VALUES (?, ?, ?)
+		 ON CONFLICT (id) DO UPDATE SET name = excluded.name, snapshot_id = excluded.snapshot_id`,
+		id, name, snapshotID)
+	return changed, err
+}
+
+//UpsertPlaylistTrack records that track_id appears in playlist_id
+func (s *Store) UpsertPlaylistTrack(playlistID, trackID, addedAt string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO playlist_tracks (playlist_id, track_id, added_at) VALUES (?, ?, ?)
+		 ON CONFLICT (playlist_id, track_id) DO UPDATE SET added_at = excluded.added_at`, playlistID, trackID, addedAt)
+	return err
+}
@@ -0,0 +1,272 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/schairez/spotifywork/spotifyservice/spotifyapi"
+)
+
+const pageSize = 50
+
+//Syncer performs a full library sync for one user: saved tracks, saved
+//albums, followed artists, and all playlists with their tracks. Each
+//endpoint is paginated independently, up to workers at a time, and persists
+//a resume cursor after every page so a crash picks back up where it left off
+type Syncer struct {
+	client  *spotifyapi.Client
+	store   *Store
+	workers int
+	qps     float64
+}
+
+//NewSyncer returns a Syncer that fans out up to workers pages/playlists at a
+//time against client, persisting progress to store. If qps is positive, all
+//requests made by a job share a client-side limiter capped at qps instead of
+//the transport's default, so operators can tune bulk pagination separately
+//from interactive traffic
+func NewSyncer(client *spotifyapi.Client, store *Store, workers int, qps float64) *Syncer {
+	if workers <= 0 {
+		workers = 4
+	}
+	return &Syncer{client: client, store: store, workers: workers, qps: qps}
+}
+
+//StartJob runs a library sync for userID in the background and returns its
+//job ID immediately so callers can poll progress. If resumeJobID is
+//non-empty, the existing job with that ID is resumed from its persisted
+//cursors instead of starting a new job from scratch; otherwise a new job is
+//created
+func (s *Syncer) StartJob(userID string, token *oauth2.Token, resumeJobID string) (string, error) {
+	jobID := resumeJobID
+	if jobID == "" {
+		job, err := s.store.CreateJob(userID)
+		if err != nil {
+			return "", err
+		}
+		jobID = job.ID
+	} else if job, err := s.store.GetJob(jobID); err != nil {
+		return "", fmt.Errorf("sync: resuming job %q: %w", jobID, err)
+	} else if job.UserID != userID {
+		return "", fmt.Errorf("sync: job %q does not belong to this user", jobID)
+	}
+	go func() {
+		if err := s.run(context.Background(), jobID, userID, token); err != nil {
+			log.Printf("sync: job %s for user %q failed: %v", jobID, userID, err)
+		}
+	}()
+	return jobID, nil
+}
+
+func (s *Syncer) run(ctx context.Context, jobID, userID string, token *oauth2.Token) error {
+	if err := s.store.SetJobStatus(jobID, StatusRunning, ""); err != nil {
+		return err
+	}
+
+	if s.qps > 0 {
+		ctx = spotifyapi.WithQPSOverride(ctx, s.qps)
+	}
+
+	group, gctx := errgroup.WithContext(ctx)
+	group.SetLimit(s.workers)
+
+	group.Go(func() error { return s.syncSavedTracks(gctx, jobID, userID, token) })
+	group.Go(func() error { return s.syncSavedAlbums(gctx, jobID, userID, token) })
+	group.Go(func() error { return s.syncFollowedArtists(gctx, jobID, userID, token) })
+	group.Go(func() error { return s.syncPlaylists(gctx, jobID, userID, token) })
+
+	if err := group.Wait(); err != nil {
+		s.store.SetJobStatus(jobID, StatusFailed, err.Error())
+		return err
+	}
+	return s.store.SetJobStatus(jobID, StatusDone, "")
+}
+
+func (s *Syncer) syncSavedTracks(ctx context.Context, jobID, userID string, token *oauth2.Token) error {
+	const endpoint = "saved_tracks"
+	offset, _, err := s.store.LoadCursor(jobID, endpoint)
+	if err != nil {
+		return fmt.Errorf("sync: loading %s cursor: %w", endpoint, err)
+	}
+	for {
+		limit := pageSize
+		page, err := s.client.GetUserSavedTracks(ctx, token, &spotifyapi.QParams{Limit: &limit, Offset: &offset})
+		if err != nil {
+			return fmt.Errorf("sync: fetching saved tracks at offset %d: %w", offset, err)
+		}
+		for _, item := range page.Items {
+			if err := s.store.UpsertTrack(item.Track.ID, item.Track.Name); err != nil {
+				return err
+			}
+			if err := s.store.UpsertSavedTrack(userID, item.Track.ID, item.AddedAt); err != nil {
+				return err
+			}
+		}
+		offset += len(page.Items)
+		if err := s.store.SaveCursor(jobID, endpoint, offset, ""); err != nil {
+			return err
+		}
+		if err := s.store.IncrementJobProgress(jobID); err != nil {
+			return err
+		}
+		if page.Next == "" || len(page.Items) == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *Syncer) syncSavedAlbums(ctx context.Context, jobID, userID string, token *oauth2.Token) error {
+	const endpoint = "saved_albums"
+	offset, _, err := s.store.LoadCursor(jobID, endpoint)
+	if err != nil {
+		return fmt.Errorf("sync: loading %s cursor: %w", endpoint, err)
+	}
+	for {
+		limit := pageSize
+		page, err := s.client.GetUserSavedAlbums(ctx, token, &spotifyapi.QParams{Limit: &limit, Offset: &offset})
+		if err != nil {
+			return fmt.Errorf("sync: fetching saved albums at offset %d: %w", offset, err)
+		}
+		for _, item := range page.Items {
+			if err := s.store.UpsertAlbum(item.Album.ID, item.Album.Name); err != nil {
+				return err
+			}
+			if err := s.store.UpsertSavedAlbum(userID, item.Album.ID, item.AddedAt); err != nil {
+				return err
+			}
+		}
+		offset += len(page.Items)
+		if err := s.store.SaveCursor(jobID, endpoint, offset, ""); err != nil {
+			return err
+		}
+		if err := s.store.IncrementJobProgress(jobID); err != nil {
+			return err
+		}
+		if page.Next == "" || len(page.Items) == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *Syncer) syncFollowedArtists(ctx context.Context, jobID, userID string, token *oauth2.Token) error {
+	const endpoint = "followed_artists"
+	//followed artists is cursor-paged rather than offset-paged; we reuse the
+	//cursor row's snapshot_id column to stash the "after" cursor
+	_, after, err := s.store.LoadCursor(jobID, endpoint)
+	if err != nil {
+		return fmt.Errorf("sync: loading %s cursor: %w", endpoint, err)
+	}
+	for {
+		limit := pageSize
+		params := spotifyapi.QParams{Limit: &limit}
+		if after != "" {
+			params.After = &after
+		}
+		page, err := s.client.GetFollowedArtists(ctx, token, &params)
+		if err != nil {
+			return fmt.Errorf("sync: fetching followed artists after %q: %w", after, err)
+		}
+		for _, artist := range page.Items {
+			if err := s.store.UpsertArtist(artist.ID, artist.Name); err != nil {
+				return err
+			}
+			if err := s.store.UpsertFollowedArtist(userID, artist.ID); err != nil {
+				return err
+			}
+		}
+		after = page.NextCursor
+		if err := s.store.SaveCursor(jobID, endpoint, 0, after); err != nil {
+			return err
+		}
+		if err := s.store.IncrementJobProgress(jobID); err != nil {
+			return err
+		}
+		if len(page.Items) == 0 || after == "" {
+			return nil
+		}
+	}
+}
+
+func (s *Syncer) syncPlaylists(ctx context.Context, jobID, userID string, token *oauth2.Token) error {
+	const endpoint = "playlists"
+	offset, _, err := s.store.LoadCursor(jobID, endpoint)
+	if err != nil {
+		return fmt.Errorf("sync: loading %s cursor: %w", endpoint, err)
+	}
+	//playlist track syncs get their own workers-limited errgroup, separate
+	//from the one syncPlaylists itself runs under: nesting group.Go calls in
+	//that same, shared errgroup would make syncPlaylists block acquiring a
+	//second slot from itself, hanging forever whenever workers == 1
+	tracks, tctx := errgroup.WithContext(ctx)
+	tracks.SetLimit(s.workers)
+	for {
+		limit := pageSize
+		page, err := s.client.GetUserPlaylists(ctx, token, &spotifyapi.QParams{Limit: &limit, Offset: &offset})
+		if err != nil {
+			return fmt.Errorf("sync: fetching playlists at offset %d: %w", offset, err)
+		}
+		for _, p := range page.Items {
+			playlist := p
+			//skip re-fetching a playlist's tracks if its snapshot_id hasn't
+			//changed since the last sync
+			changed, err := s.store.UpsertPlaylistIfChanged(playlist.ID, playlist.Name, playlist.SnapshotID)
+			if err != nil {
+				return err
+			}
+			if changed {
+				tracks.Go(func() error {
+					return s.syncPlaylistTracks(tctx, jobID, playlist.ID, token)
+				})
+			}
+		}
+		offset += len(page.Items)
+		if err := s.store.SaveCursor(jobID, endpoint, offset, ""); err != nil {
+			return err
+		}
+		if err := s.store.IncrementJobProgress(jobID); err != nil {
+			return err
+		}
+		if page.Next == "" || len(page.Items) == 0 {
+			break
+		}
+	}
+	return tracks.Wait()
+}
+
+func (s *Syncer) syncPlaylistTracks(ctx context.Context, jobID, playlistID string, token *oauth2.Token) error {
+	endpoint := "playlist_tracks:" + playlistID
+	offset, _, err := s.store.LoadCursor(jobID, endpoint)
+	if err != nil {
+		return fmt.Errorf("sync: loading %s cursor: %w", endpoint, err)
+	}
+	for {
+		limit := pageSize
+		page, err := s.client.GetPlaylistTracks(ctx, token, playlistID, &spotifyapi.QParams{Limit: &limit, Offset: &offset})
+		if err != nil {
+			return fmt.Errorf("sync: fetching tracks for playlist %q at offset %d: %w", playlistID, offset, err)
+		}
+		for _, item := range page.Items {
+			if err := s.store.UpsertTrack(item.Track.ID, item.Track.Name); err != nil {
+				return err
+			}
+			if err := s.store.UpsertPlaylistTrack(playlistID, item.Track.ID, item.AddedAt); err != nil {
+				return err
+			}
+		}
+		offset += len(page.Items)
+		if err := s.store.SaveCursor(jobID, endpoint, offset, ""); err != nil {
+			return err
+		}
+		if page.Next == "" || len(page.Items) == 0 {
+			return nil
+		}
+	}
+}
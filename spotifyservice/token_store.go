@@ -0,0 +1,143 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package spotifyservice
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.etcd.io/bbolt"
+	"golang.org/x/oauth2"
+)
+
+//ErrTokenNotFound is returned by TokenStore.LoadToken when no token has been
+//stored for the given user ID
+var ErrTokenNotFound = errors.New("spotifyservice: token not found")
+
+var tokensBucket = []byte("tokens")
+
+//TokenStore persists a user's OAuth2 token across server restarts, keyed by
+//their stable provider user ID, so they don't have to re-consent every time
+type TokenStore interface {
+	SaveToken(userID string, token *oauth2.Token) error
+	LoadToken(userID string) (*oauth2.Token, error)
+	DeleteToken(userID string) error
+	Close() error
+}
+
+//boltTokenStore is a TokenStore backed by a BoltDB file on disk, with tokens
+//encrypted at rest using AES-GCM
+type boltTokenStore struct {
+	db  *bbolt.DB
+	gcm cipher.AEAD
+}
+
+//newBoltTokenStore opens (creating if needed) a BoltDB file at path and
+//returns a TokenStore that encrypts values with hexKey, a hex-encoded
+//AES-128/192/256 key
+func newBoltTokenStore(path, hexKey string) (*boltTokenStore, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("token store: decoding encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("token store: building AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("token store: building AES-GCM: %w", err)
+	}
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("token store: opening %q: %w", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tokensBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("token store: creating bucket: %w", err)
+	}
+	return &boltTokenStore{db: db, gcm: gcm}, nil
+}
+
+func (s *boltTokenStore) encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, s.gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("token store: generating nonce: %w", err)
+	}
+	return s.gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (s *boltTokenStore) decrypt(ciphertext []byte) ([]byte, error) {
+	nonceSize := s.gcm.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("token store: ciphertext shorter than nonce")
+	}
+	nonce, sealed := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	return s.gcm.Open(nil, nonce, sealed, nil)
+}
+
+//SaveToken encrypts and upserts token under userID
+func (s *boltTokenStore) SaveToken(userID string, token *oauth2.Token) error {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("token store: marshaling token: %w", err)
+	}
+	ciphertext, err := s.encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(tokensBucket).Put([]byte(userID), ciphertext)
+	})
+}
+
+//LoadToken decrypts and returns the token stored for userID, or
+//ErrTokenNotFound if none exists
+func (s *boltTokenStore) LoadToken(userID string) (*oauth2.Token, error) {
+	var ciphertext []byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(tokensBucket).Get([]byte(userID))
+		if v == nil {
+			return ErrTokenNotFound
+		}
+		ciphertext = append([]byte(nil), v...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := s.decrypt(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("token store: decrypting token for %q: %w", userID, err)
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return nil, fmt.Errorf("token store: unmarshaling token: %w", err)
+	}
+	return &token, nil
+}
+
+//DeleteToken removes any token stored for userID
+func (s *boltTokenStore) DeleteToken(userID string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(tokensBucket).Delete([]byte(userID))
+	})
+}
+
+func (s *boltTokenStore) Close() error {
+	return s.db.Close()
+}
+
+var _ TokenStore = (*boltTokenStore)(nil)
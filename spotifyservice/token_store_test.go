@@ -0,0 +1,91 @@
+// Copyright 2020 Sergio Chairez. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package spotifyservice
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"testing"
+)
+
+//newTestTokenStore returns a boltTokenStore with a fresh AES-GCM cipher and
+//no backing db, enough to exercise encrypt/decrypt in isolation
+func newTestTokenStore(t *testing.T) *boltTokenStore {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generating test key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("building AES cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("building AES-GCM: %v", err)
+	}
+	return &boltTokenStore{gcm: gcm}
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	s := newTestTokenStore(t)
+	plaintext := []byte(`{"access_token":"abc123"}`)
+
+	ciphertext, err := s.encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if bytes.Equal(ciphertext, plaintext) {
+		t.Fatal("ciphertext must not equal plaintext")
+	}
+
+	got, err := s.decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("decrypt(encrypt(x)) = %q, want %q", got, plaintext)
+	}
+}
+
+func TestEncryptUsesRandomNonce(t *testing.T) {
+	s := newTestTokenStore(t)
+	plaintext := []byte("same plaintext")
+
+	first, err := s.encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	second, err := s.encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if bytes.Equal(first, second) {
+		t.Fatal("two encryptions of the same plaintext must not produce identical ciphertext")
+	}
+}
+
+func TestDecryptRejectsTamperedCiphertext(t *testing.T) {
+	s := newTestTokenStore(t)
+	ciphertext, err := s.encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[len(tampered)-1] ^= 0xFF
+
+	if _, err := s.decrypt(tampered); err == nil {
+		t.Fatal("decrypt of tampered ciphertext should fail")
+	}
+}
+
+func TestDecryptRejectsShortCiphertext(t *testing.T) {
+	s := newTestTokenStore(t)
+	if _, err := s.decrypt([]byte("short")); err == nil {
+		t.Fatal("decrypt of ciphertext shorter than the nonce should fail")
+	}
+}